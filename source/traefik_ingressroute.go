@@ -5,20 +5,32 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blake/external-mdns/resource"
 	"github.com/jpillora/go-tld"
 	informers "github.com/traefik/traefik/v3/pkg/provider/kubernetes/crd/generated/informers/externalversions"
+	traefikcontainous "github.com/traefik/traefik/v3/pkg/provider/kubernetes/crd/traefik/v1alpha1"
 	traefikio "github.com/traefik/traefik/v3/pkg/provider/kubernetes/crd/traefikio/v1alpha1"
 	"github.com/traefik/traefik/v3/pkg/rules"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	networkinginformers "k8s.io/client-go/informers/networking/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 )
 
+// traefikServiceLabelSelector restricts the Service informer to the
+// Traefik Service(s) whose destination IPs are advertised alongside each
+// IngressRoute record.
+const traefikServiceLabelSelector = "app.kubernetes.io/name=traefik"
+
 var matchers = []string{
 	"ClientIP",
 	"Method",
@@ -34,22 +46,188 @@ var matchers = []string{
 	"QueryRegexp",
 }
 
+// matchersTCP are the rule matchers understood by IngressRouteTCP's Match
+// expressions. Only HostSNI carries a hostname we can advertise.
+var matchersTCP = []string{
+	"ClientIP",
+	"ALPN",
+	"HostSNI",
+}
+
+// defaultEntryPointPorts maps the well-known Traefik entry point names to
+// their conventional port numbers, used when no explicit mapping is given
+// for an IngressRouteTCP/UDP's entry points.
+var defaultEntryPointPorts = map[string]int{
+	"web":       80,
+	"websecure": 443,
+}
+
+// dstIPResolveInterval is how often the Traefik Service's destination IPs
+// are re-resolved, independent of Service informer events. This catches
+// cases like AWS ELBs where Status.LoadBalancer.Ingress[].Hostname stays
+// the same while the A records it resolves to rotate underneath it.
+const dstIPResolveInterval = 5 * time.Minute
+
+// udpHostnameAnnotation is the explicit opt-in an IngressRouteUDP must
+// carry to be advertised. UDP routes have no Host matcher to extract a
+// hostname from, so it is never guessed from the object's name.
+const udpHostnameAnnotation = "external-mdns.io/hostname"
+
+// traefikControllerName is the spec.controller value Traefik sets on the
+// IngressClass resources it owns.
+const traefikControllerName = "traefik.io/ingress-controller"
+
 // TraefikIngressRouteSource handles adding, updating, or removing mDNS record advertisements
 type TraefikIngressRouteSource struct {
 	namespace      string
 	notifyChan     chan<- resource.Resource
 	sharedInformer cache.SharedIndexInformer
-	dstIPAddr      []net.IP
+	// legacyInformer watches the traefik.containo.us/v1alpha1 IngressRoutes
+	// still served by some clusters alongside the traefik.io/v1alpha1 CRDs.
+	legacyInformer cache.SharedIndexInformer
+	tcpInformer    cache.SharedIndexInformer
+	udpInformer    cache.SharedIndexInformer
+	// serviceInformer watches the Traefik Service(s) so that dstIPAddr stays
+	// current across LoadBalancer IP rotations and Traefik restarts.
+	serviceInformer cache.SharedIndexInformer
+	// entryPointPorts maps a Traefik entry point name to the port it's bound
+	// to. IngressRouteTCP/UDP entry points are resolved against it only to
+	// log routes with no resolvable port; the ports are not advertised.
+	entryPointPorts map[string]int
+	// ingressClass restricts advertised routes to those owned by a specific
+	// Traefik instance. Empty means advertise routes of any class.
+	ingressClass string
+	// ingressClassInformer backs the IngressClassName-based fallback in
+	// matchesIngressClass with a cache, so resolving it doesn't mean a
+	// live API call on every buildRecords invocation. Only populated when
+	// ingressClass is set.
+	ingressClassInformer cache.SharedIndexInformer
+	// resolveLoadBalancerHostname enables DNS resolution of a LoadBalancer
+	// Ingress' Hostname when no IP is reported, as e.g. AWS ELBs do.
+	resolveLoadBalancerHostname bool
+	// hostnameCandidates are the .local hostnames HostRegexp(...) rules are
+	// expanded against, since a regexp alone can't be turned back into the
+	// concrete names mDNS needs to advertise.
+	hostnameCandidates []string
+	dstIPMu            sync.Mutex
+	dstIPAddr          []net.IP
 }
 
 func (s *TraefikIngressRouteSource) Run(stopCh chan struct{}) error {
-	s.sharedInformer.Run(stopCh)
-	if !cache.WaitForCacheSync(stopCh, s.sharedInformer.HasSynced) {
+	var synced []cache.InformerSynced
+
+	for _, informer := range []cache.SharedIndexInformer{
+		s.sharedInformer,
+		s.legacyInformer,
+		s.tcpInformer,
+		s.udpInformer,
+		s.serviceInformer,
+		s.ingressClassInformer,
+	} {
+		if informer == nil {
+			continue
+		}
+		go informer.Run(stopCh)
+		synced = append(synced, informer.HasSynced)
+	}
+
+	if !cache.WaitForCacheSync(stopCh, synced...) {
 		runtime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
 	}
+
+	if s.serviceInformer != nil {
+		go s.runDstIPResolveLoop(stopCh)
+	}
 	return nil
 }
 
+// runDstIPResolveLoop periodically re-resolves the Traefik Service's
+// destination IPs and re-emits cached records, so a LoadBalancer Hostname
+// whose backing A records rotate doesn't require a Service event to pick
+// up the change.
+func (s *TraefikIngressRouteSource) runDstIPResolveLoop(stopCh chan struct{}) {
+	ticker := time.NewTicker(dstIPResolveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.onServiceChange(nil)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// onServiceChange recomputes dstIPAddr from the Service informer's current
+// cache and re-emits every cached IngressRoute record with the refreshed IP
+// set, so a Traefik restart or LoadBalancer IP rotation doesn't require
+// restarting external-mdns. When the IP set actually changed, the old IP
+// set is withdrawn with Deleted records first so the responder doesn't
+// keep advertising a stale address.
+func (s *TraefikIngressRouteSource) onServiceChange(interface{}) {
+	oldIPAddrs := s.ipAddrs()
+
+	dstIPs := s.collectDstIPAddr()
+	newIPAddrs := ipStrings(dstIPs)
+
+	s.dstIPMu.Lock()
+	s.dstIPAddr = dstIPs
+	s.dstIPMu.Unlock()
+	log.Printf("Traefik service IP addresses: %v", dstIPs)
+
+	withdraw := len(oldIPAddrs) > 0 && !sameIPSet(oldIPAddrs, newIPAddrs)
+
+	for _, informer := range []cache.SharedIndexInformer{
+		s.sharedInformer,
+		s.legacyInformer,
+		s.tcpInformer,
+		s.udpInformer,
+	} {
+		if informer == nil {
+			continue
+		}
+		for _, obj := range informer.GetStore().List() {
+			if withdraw {
+				stale, err := s.buildRecordsForIPs(obj, resource.Deleted, oldIPAddrs)
+				if err != nil {
+					log.Printf("Error withdrawing stale Traefik ingress route records: %v", err)
+				} else {
+					for _, record := range stale {
+						s.notifyChan <- record
+					}
+				}
+			}
+
+			records, err := s.buildRecords(obj, resource.Added)
+			if err != nil {
+				log.Printf("Error rebuilding Traefik ingress route records: %v", err)
+				continue
+			}
+			for _, record := range records {
+				s.notifyChan <- record
+			}
+		}
+	}
+}
+
+// collectDstIPAddr resolves the destination IPs of every Service currently
+// in the Service informer's cache.
+func (s *TraefikIngressRouteSource) collectDstIPAddr() []net.IP {
+	var dstIPs []net.IP
+	for _, obj := range s.serviceInformer.GetStore().List() {
+		service, ok := obj.(*corev1.Service)
+		if !ok {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		dstIPs = append(dstIPs, resolveServiceIPs(ctx, service, s.resolveLoadBalancerHostname)...)
+		cancel()
+	}
+	return dstIPs
+}
+
 func (s *TraefikIngressRouteSource) onAdd(obj interface{}) {
 	advertiseRecords, err := s.buildRecords(obj, resource.Added)
 	if err != nil {
@@ -96,20 +274,151 @@ func (s *TraefikIngressRouteSource) onUpdate(oldObj, newObj interface{}) {
 	}
 }
 
-func (s *TraefikIngressRouteSource) buildRecords(obj interface{}, action string) ([]resource.Resource, error) {
-	var records []resource.Resource
+// ingressRouteMatches extracts the namespace and route match rules from
+// either the traefik.io or the legacy traefik.containo.us IngressRoute,
+// since both CRDs carry the same Spec.Routes[].Match shape.
+func ingressRouteMatches(obj interface{}) (string, []string, bool) {
+	switch ingress := obj.(type) {
+	case *traefikio.IngressRoute:
+		var matches []string
+		for _, route := range ingress.Spec.Routes {
+			matches = append(matches, route.Match)
+		}
+		return ingress.Namespace, matches, true
+	case *traefikcontainous.IngressRoute:
+		var matches []string
+		for _, route := range ingress.Spec.Routes {
+			matches = append(matches, route.Match)
+		}
+		return ingress.Namespace, matches, true
+	default:
+		return "", nil, false
+	}
+}
+
+// ingressRouteClass extracts the object metadata and IngressClassName from
+// any of the IngressRoute CRD kinds, used to apply --traefik-ingress-class
+// filtering ahead of the per-kind record building.
+func ingressRouteClass(obj interface{}) (v1.ObjectMeta, *string, bool) {
+	switch ingress := obj.(type) {
+	case *traefikio.IngressRoute:
+		return ingress.ObjectMeta, ingress.Spec.IngressClassName, true
+	case *traefikcontainous.IngressRoute:
+		return ingress.ObjectMeta, ingress.Spec.IngressClassName, true
+	case *traefikio.IngressRouteTCP:
+		return ingress.ObjectMeta, ingress.Spec.IngressClassName, true
+	case *traefikio.IngressRouteUDP:
+		return ingress.ObjectMeta, ingress.Spec.IngressClassName, true
+	default:
+		return v1.ObjectMeta{}, nil, false
+	}
+}
 
-	ingress, ok := obj.(*traefikio.IngressRoute)
+// matchesIngressClass reports whether a route belongs to the Traefik
+// instance this source is configured to watch, following the same
+// ingress.class annotation / IngressClassName precedence Traefik itself
+// uses to pick up routes. An IngressClassName is only honored if it names
+// an IngressClass whose spec.Controller is traefikControllerName, checked
+// against ingressClassInformer's cache rather than a live API call.
+func (s *TraefikIngressRouteSource) matchesIngressClass(meta v1.ObjectMeta, ingressClassName *string) bool {
+	if s.ingressClass == "" {
+		return true
+	}
+
+	if class, ok := meta.Annotations["kubernetes.io/ingress.class"]; ok {
+		return class == s.ingressClass
+	}
+
+	if ingressClassName == nil || *ingressClassName != s.ingressClass || s.ingressClassInformer == nil {
+		return false
+	}
+
+	obj, exists, err := s.ingressClassInformer.GetStore().GetByKey(*ingressClassName)
+	if err != nil || !exists {
+		log.Printf("Unable to find IngressClass %s in cache: %v", *ingressClassName, err)
+		return false
+	}
+
+	ic, ok := obj.(*networkingv1.IngressClass)
 	if !ok {
-		return records, nil
+		return false
 	}
 
+	return ic.Spec.Controller == traefikControllerName
+}
+
+func (s *TraefikIngressRouteSource) buildRecords(obj interface{}, action string) ([]resource.Resource, error) {
+	return s.buildRecordsForIPs(obj, action, s.ipAddrs())
+}
+
+// buildRecordsForIPs is buildRecords with the destination IPs passed in
+// explicitly rather than read from the current s.dstIPAddr, so a caller
+// can build records for an IP set that no longer matches the source's
+// current state (e.g. to emit Deleted records for a stale IP set).
+func (s *TraefikIngressRouteSource) buildRecordsForIPs(obj interface{}, action string, ipAddrs []string) ([]resource.Resource, error) {
+	if meta, ingressClassName, ok := ingressRouteClass(obj); ok && !s.matchesIngressClass(meta, ingressClassName) {
+		return nil, nil
+	}
+
+	switch ingress := obj.(type) {
+	case *traefikio.IngressRouteTCP:
+		return s.buildTCPRecords(ingress, action, ipAddrs)
+	case *traefikio.IngressRouteUDP:
+		return s.buildUDPRecords(ingress, action, ipAddrs)
+	default:
+		return s.buildHTTPRecords(obj, action, ipAddrs)
+	}
+}
+
+// ipStrings renders a slice of net.IP as strings, skipping any zero-value
+// entries.
+func ipStrings(ips []net.IP) []string {
 	var ipAddrs []string
-	for _, ip := range s.dstIPAddr {
+	for _, ip := range ips {
 		if len(ip) != 0 {
 			ipAddrs = append(ipAddrs, ip.String())
 		}
 	}
+	return ipAddrs
+}
+
+// sameIPSet reports whether a and b contain the same IP strings, ignoring
+// order.
+func sameIPSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, ip := range a {
+		counts[ip]++
+	}
+	for _, ip := range b {
+		counts[ip]--
+	}
+	for _, c := range counts {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ipAddrs returns the current destination IPs as strings, guarding against
+// concurrent refreshes from the periodic re-resolution in Run.
+func (s *TraefikIngressRouteSource) ipAddrs() []string {
+	s.dstIPMu.Lock()
+	defer s.dstIPMu.Unlock()
+
+	return ipStrings(s.dstIPAddr)
+}
+
+func (s *TraefikIngressRouteSource) buildHTTPRecords(obj interface{}, action string, ipAddrs []string) ([]resource.Resource, error) {
+	var records []resource.Resource
+
+	namespace, matches, ok := ingressRouteMatches(obj)
+	if !ok {
+		return records, nil
+	}
 
 	parser, err := rules.NewParser(matchers)
 	if err != nil {
@@ -117,8 +426,8 @@ func (s *TraefikIngressRouteSource) buildRecords(obj interface{}, action string)
 	}
 
 	var hostname string
-	for _, route := range ingress.Spec.Routes {
-		parsed, err := parser.Parse(route.Match)
+	for _, match := range matches {
+		parsed, err := parser.Parse(match)
 		if err != nil {
 			return nil, err
 		}
@@ -129,7 +438,7 @@ func (s *TraefikIngressRouteSource) buildRecords(obj interface{}, action string)
 		}
 
 		tree := treeBuilder()
-		hosts, err := extractHosts(tree)
+		hosts, err := extractHosts(tree, s.hostnameCandidates)
 		if err != nil {
 			return nil, err
 		}
@@ -157,7 +466,7 @@ func (s *TraefikIngressRouteSource) buildRecords(obj interface{}, action string)
 				SourceType: "ingress",
 				Action:     action,
 				Name:       hostname,
-				Namespace:  ingress.Namespace,
+				Namespace:  namespace,
 				IPs:        ipAddrs,
 			}
 
@@ -168,83 +477,263 @@ func (s *TraefikIngressRouteSource) buildRecords(obj interface{}, action string)
 	return records, nil
 }
 
+// entryPoints resolves each named entry point to a port, falling back to
+// defaultEntryPointPorts and logging any entry point that can't be resolved.
+func (s *TraefikIngressRouteSource) entryPoints(entryPoints []string) []int {
+	var ports []int
+	for _, ep := range entryPoints {
+		if port, ok := s.entryPointPorts[ep]; ok {
+			ports = append(ports, port)
+			continue
+		}
+		if port, ok := defaultEntryPointPorts[ep]; ok {
+			ports = append(ports, port)
+			continue
+		}
+		log.Printf("Unable to resolve port for Traefik entry point %q", ep)
+	}
+	return ports
+}
+
+// buildTCPRecords advertises an A record for each .local HostSNI(...) host
+// of an IngressRouteTCP. The records carry no protocol or port, so no
+// SRV/PTR records are published for them.
+func (s *TraefikIngressRouteSource) buildTCPRecords(ingress *traefikio.IngressRouteTCP, action string, ipAddrs []string) ([]resource.Resource, error) {
+	var records []resource.Resource
+
+	if len(s.entryPoints(ingress.Spec.EntryPoints)) == 0 {
+		log.Printf("No resolvable entry point ports for IngressRouteTCP %s/%s", ingress.Namespace, ingress.Name)
+	}
+
+	parser, err := rules.NewParser(matchersTCP)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, route := range ingress.Spec.Routes {
+		parsed, err := parser.Parse(route.Match)
+		if err != nil {
+			return nil, err
+		}
+
+		treeBuilder, ok := parsed.(rules.TreeBuilder)
+		if !ok {
+			return nil, fmt.Errorf("unable to parse match rule")
+		}
+
+		hosts, err := extractHostsTCP(treeBuilder())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, host := range hosts {
+			// Skip hostnames that do not use .local
+			if !strings.HasSuffix(host, ".local") {
+				continue
+			}
+
+			records = append(records, resource.Resource{
+				SourceType: "ingressroutetcp",
+				Action:     action,
+				Name:       host,
+				Namespace:  ingress.Namespace,
+				IPs:        ipAddrs,
+			})
+		}
+	}
+
+	return records, nil
+}
+
+// buildUDPRecords advertises an A record for an IngressRouteUDP, using the
+// hostname from its udpHostnameAnnotation: UDP routes have no Host matcher,
+// so the hostname is never guessed from the object's name, and a route
+// without the annotation is skipped rather than advertised. As with
+// buildTCPRecords, the record carries no protocol or port, so no SRV/PTR
+// records are published for it.
+func (s *TraefikIngressRouteSource) buildUDPRecords(ingress *traefikio.IngressRouteUDP, action string, ipAddrs []string) ([]resource.Resource, error) {
+	var records []resource.Resource
+
+	if len(ingress.Spec.Routes) == 0 {
+		return records, nil
+	}
+
+	host, ok := ingress.Annotations[udpHostnameAnnotation]
+	if !ok || host == "" {
+		log.Printf("Skipping IngressRouteUDP %s/%s: no %s annotation, hostname can't be inferred from a UDP route", ingress.Namespace, ingress.Name, udpHostnameAnnotation)
+		return records, nil
+	}
+	if !strings.HasSuffix(host, ".local") {
+		host = fmt.Sprintf("%s.local", host)
+	}
+
+	if len(s.entryPoints(ingress.Spec.EntryPoints)) == 0 {
+		log.Printf("No resolvable entry point ports for IngressRouteUDP %s/%s", ingress.Namespace, ingress.Name)
+	}
+
+	records = append(records, resource.Resource{
+		SourceType: "ingressrouteudp",
+		Action:     action,
+		Name:       host,
+		Namespace:  ingress.Namespace,
+		IPs:        ipAddrs,
+	})
+
+	return records, nil
+}
+
 func NewTraefikIngressRouteWatcher(
 	client kubernetes.Interface,
 	factory informers.SharedInformerFactory,
 	namespace string,
 	notifyChan chan<- resource.Resource,
+	disableLegacy bool,
+	disableNew bool,
+	entryPointPorts map[string]int,
+	ingressClass string,
+	resolveLoadBalancerHostname bool,
+	hostnameCandidates []string,
 ) TraefikIngressRouteSource {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	i := &TraefikIngressRouteSource{
+		namespace:                   namespace,
+		notifyChan:                  notifyChan,
+		entryPointPorts:             entryPointPorts,
+		ingressClass:                ingressClass,
+		resolveLoadBalancerHostname: resolveLoadBalancerHostname,
+		hostnameCandidates:          hostnameCandidates,
+	}
 
-	// Get the Traefik service IP addresses
-	dstIPs, err := getDstIPAddr(ctx, client)
-	if err != nil {
-		log.Fatalf("Failed to get Traefik service IP addresses: %v", err)
+	serviceInformer := coreinformers.NewFilteredServiceInformer(client, v1.NamespaceAll, 0, cache.Indexers{},
+		func(options *v1.ListOptions) {
+			options.LabelSelector = traefikServiceLabelSelector
+		})
+	_, _ = serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    i.onServiceChange,
+		UpdateFunc: func(_, newObj interface{}) { i.onServiceChange(newObj) },
+		DeleteFunc: i.onServiceChange,
+	})
+	i.serviceInformer = serviceInformer
+
+	if ingressClass != "" {
+		i.ingressClassInformer = networkinginformers.NewIngressClassInformer(client, 0, cache.Indexers{})
 	}
-	log.Printf("Traefik service IP addresses: %v", dstIPs)
 
-	ingressInformer := factory.Traefik().V1alpha1().IngressRoutes().Informer()
-	i := &TraefikIngressRouteSource{
-		namespace:      namespace,
-		notifyChan:     notifyChan,
-		sharedInformer: ingressInformer,
-		dstIPAddr:      dstIPs,
+	if !disableNew {
+		ingressInformer := factory.Traefik().V1alpha1().IngressRoutes().Informer()
+		_, _ = ingressInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    i.onAdd,
+			UpdateFunc: i.onUpdate,
+			DeleteFunc: i.onDelete,
+		})
+		i.sharedInformer = ingressInformer
 	}
 
-	_, _ = ingressInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    i.onAdd,
-		UpdateFunc: i.onUpdate,
-		DeleteFunc: i.onDelete,
-	})
+	if !disableLegacy {
+		legacyInformer := factory.Containous().V1alpha1().IngressRoutes().Informer()
+		_, _ = legacyInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    i.onAdd,
+			UpdateFunc: i.onUpdate,
+			DeleteFunc: i.onDelete,
+		})
+		i.legacyInformer = legacyInformer
+	}
+
+	if !disableNew {
+		tcpInformer := factory.Traefik().V1alpha1().IngressRouteTCPs().Informer()
+		_, _ = tcpInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    i.onAdd,
+			UpdateFunc: i.onUpdate,
+			DeleteFunc: i.onDelete,
+		})
+		i.tcpInformer = tcpInformer
+
+		udpInformer := factory.Traefik().V1alpha1().IngressRouteUDPs().Informer()
+		_, _ = udpInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    i.onAdd,
+			UpdateFunc: i.onUpdate,
+			DeleteFunc: i.onDelete,
+		})
+		i.udpInformer = udpInformer
+	}
 
 	return *i
 }
 
-func getDstIPAddr(ctx context.Context, client kubernetes.Interface) ([]net.IP, error) {
-	// Find the Traefik service
-	services, err := client.CoreV1().Services("").List(ctx, v1.ListOptions{})
-	if err != nil {
-		return nil, err
-	}
+// resolveServiceIPs extracts the usable destination IPs from a single
+// Traefik Service, resolving an ExternalName via DNS, and a LoadBalancer
+// Hostname when no IP is reported and resolveLoadBalancerHostname is set.
+func resolveServiceIPs(ctx context.Context, service *corev1.Service, resolveLoadBalancerHostname bool) []net.IP {
+	var ips []net.IP
 
-	var dstIPs []net.IP
-	for _, service := range services.Items {
-		if service.Labels["app.kubernetes.io/name"] != "traefik" {
-			continue
-		}
+	switch service.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ip := net.ParseIP(ingress.IP); ip != nil {
+				ips = append(ips, ip)
+				continue
+			}
 
-		// Check if the service has a LoadBalancer
-		if service.Spec.Type != "LoadBalancer" {
-			continue
-		}
+			if ingress.Hostname == "" {
+				continue
+			}
+			if !resolveLoadBalancerHostname {
+				log.Printf("LoadBalancer hostname %s not resolved: --resolve-service-loadbalancer-hostname is disabled", ingress.Hostname)
+				continue
+			}
 
-		// Get the LoadBalancer IPs
-		for _, ingress := range service.Status.LoadBalancer.Ingress {
-			ip := net.ParseIP(ingress.IP)
-			if ip != nil {
-				dstIPs = append(dstIPs, ip)
-			} else {
-				log.Printf("Unable to parse IP address %s", ingress.IP)
+			resolved, err := net.DefaultResolver.LookupIP(ctx, "ip", ingress.Hostname)
+			if err != nil {
+				log.Printf("Unable to resolve LoadBalancer hostname %s: %v", ingress.Hostname, err)
+				continue
+			}
+			ips = append(ips, resolved...)
+		}
+	case corev1.ServiceTypeClusterIP:
+		if len(service.Spec.ExternalIPs) > 0 {
+			for _, externalIP := range service.Spec.ExternalIPs {
+				if ip := net.ParseIP(externalIP); ip != nil {
+					ips = append(ips, ip)
+				}
 			}
+			break
 		}
+
+		// No LAN-reachable ExternalIP is configured, so fall back to the
+		// ClusterIP. This address is only routable from inside the cluster
+		// network: Bonjour/Avahi clients on the LAN the responder serves
+		// will not be able to reach it, so this only helps clients that
+		// also have in-cluster network access.
+		if ip := net.ParseIP(service.Spec.ClusterIP); ip != nil {
+			ips = append(ips, ip)
+		}
+	case corev1.ServiceTypeExternalName:
+		resolved, err := net.DefaultResolver.LookupIP(ctx, "ip", service.Spec.ExternalName)
+		if err != nil {
+			log.Printf("Unable to resolve ExternalName %s: %v", service.Spec.ExternalName, err)
+			break
+		}
+		ips = append(ips, resolved...)
 	}
 
-	return dstIPs, nil
+	return ips
 }
 
-func extractHosts(tree *rules.Tree) ([]string, error) {
+// extractHosts walks a parsed Match tree, collecting the hostnames it
+// advertises. Host(...) values are taken verbatim; HostRegexp(...) values
+// are expanded against hostnameCandidates, advertising every candidate the
+// regexp matches.
+func extractHosts(tree *rules.Tree, hostnameCandidates []string) ([]string, error) {
 	var hosts []string
 
 	if tree.RuleLeft != nil {
-		newHosts, err := extractHosts(tree.RuleLeft)
+		newHosts, err := extractHosts(tree.RuleLeft, hostnameCandidates)
 		if err != nil {
 			return hosts, err
 		}
 		hosts = append(hosts, newHosts...)
 	}
 	if tree.RuleRight != nil {
-		newHosts, err := extractHosts(tree.RuleRight)
+		newHosts, err := extractHosts(tree.RuleRight, hostnameCandidates)
 		if err != nil {
 			return hosts, err
 		}
@@ -260,7 +749,92 @@ func extractHosts(tree *rules.Tree) ([]string, error) {
 	case "Host":
 		hosts = append(hosts, tree.Value[0])
 	case "HostRegexp":
-		return hosts, fmt.Errorf("HostRegexp not supported")
+		matched, err := expandHostRegexp(tree.Value[0], hostnameCandidates)
+		if err != nil {
+			return hosts, err
+		}
+		hosts = append(hosts, matched...)
+	default:
+		// Do nothing
+	}
+
+	return hosts, nil
+}
+
+// hostRegexpPlaceholder matches Traefik's legacy mux-style {name} and
+// {name:pattern} placeholders inside a HostRegexp(...) rule. The name must
+// start with a letter or underscore so a quantifier like {3} or {2,4} in an
+// already-valid Go regexp isn't mistaken for a placeholder.
+var hostRegexpPlaceholder = regexp.MustCompile(`\{([A-Za-z_]\w*)(?::([^}]*))?\}`)
+
+// expandHostRegexp translates a HostRegexp(...) rule into a standard Go
+// regexp and returns every hostnameCandidate it matches. Traefik v3's
+// HostRegexp takes a plain Go regexp rather than the v2 mux syntax, so
+// patterns without any {name} placeholder are not rewritten, only anchored.
+func expandHostRegexp(pattern string, hostnameCandidates []string) ([]string, error) {
+	translated := hostRegexpPlaceholder.ReplaceAllStringFunc(pattern, func(placeholder string) string {
+		parts := hostRegexpPlaceholder.FindStringSubmatch(placeholder)
+		name, expr := parts[1], parts[2]
+		if expr == "" {
+			expr = "[^.]+"
+		}
+		return fmt.Sprintf("(?P<%s>%s)", name, expr)
+	})
+
+	// Only anchor what isn't already anchored, since a v3 rule may already
+	// be a fully-anchored Go regexp.
+	if !strings.HasPrefix(translated, "^") {
+		translated = "^" + translated
+	}
+	if !strings.HasSuffix(translated, "$") {
+		translated = translated + "$"
+	}
+
+	re, err := regexp.Compile(translated)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile HostRegexp rule %q: %w", pattern, err)
+	}
+
+	var hosts []string
+	for _, candidate := range hostnameCandidates {
+		if re.MatchString(candidate) {
+			hosts = append(hosts, candidate)
+		}
+	}
+	return hosts, nil
+}
+
+// extractHostsTCP walks a parsed IngressRouteTCP Match tree, pulling out the
+// hostnames passed to HostSNI(...), mirroring extractHosts for the HTTP
+// Host matcher.
+func extractHostsTCP(tree *rules.Tree) ([]string, error) {
+	var hosts []string
+
+	if tree.RuleLeft != nil {
+		newHosts, err := extractHostsTCP(tree.RuleLeft)
+		if err != nil {
+			return hosts, err
+		}
+		hosts = append(hosts, newHosts...)
+	}
+	if tree.RuleRight != nil {
+		newHosts, err := extractHostsTCP(tree.RuleRight)
+		if err != nil {
+			return hosts, err
+		}
+		hosts = append(hosts, newHosts...)
+	}
+
+	// Skip negation
+	if tree.Not {
+		return hosts, nil
+	}
+
+	switch tree.Matcher {
+	case "HostSNI":
+		if tree.Value[0] != "*" {
+			hosts = append(hosts, tree.Value[0])
+		}
 	default:
 		// Do nothing
 	}